@@ -1,9 +1,13 @@
 package reactor
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/aws/aws-lambda-go/events"
@@ -16,11 +20,30 @@ import (
 	"github.com/massiveco/aws-hostname/identity"
 )
 
+// route53API is the subset of the Route53 SDK client the reactor depends on.
+type route53API interface {
+	ChangeResourceRecordSets(*route53.ChangeResourceRecordSetsInput) (*route53.ChangeResourceRecordSetsOutput, error)
+	GetHostedZone(*route53.GetHostedZoneInput) (*route53.GetHostedZoneOutput, error)
+	ListResourceRecordSets(*route53.ListResourceRecordSetsInput) (*route53.ListResourceRecordSetsOutput, error)
+}
+
+// autoscalingAPI is the subset of the Auto Scaling SDK client the reactor depends on.
+type autoscalingAPI interface {
+	DescribeAutoScalingGroups(*autoscaling.DescribeAutoScalingGroupsInput) (*autoscaling.DescribeAutoScalingGroupsOutput, error)
+	CompleteLifecycleAction(*autoscaling.CompleteLifecycleActionInput) (*autoscaling.CompleteLifecycleActionOutput, error)
+	RecordLifecycleActionHeartbeat(*autoscaling.RecordLifecycleActionHeartbeatInput) (*autoscaling.RecordLifecycleActionHeartbeatOutput, error)
+}
+
+// ec2API is the subset of the EC2 SDK client the reactor depends on.
+type ec2API interface {
+	DescribeInstances(*ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error)
+}
+
 // Reactor Manage ASG Lifecycle for individual nodes
 type Reactor struct {
-	route53Client     *route53.Route53
-	autoscalingClient *autoscaling.AutoScaling
-	ec2Client         *ec2.EC2
+	route53Client     route53API
+	autoscalingClient autoscalingAPI
+	ec2Client         ec2API
 	ec2Metadata       *ec2metadata.EC2Metadata
 }
 
@@ -28,6 +51,19 @@ type autoscalingEvent struct {
 	EC2InstanceID        string `json:"EC2InstanceId"`
 	AutoScalingGroupName string
 	Event                string
+	LifecycleHookName    string
+	LifecycleActionToken string
+	LifecycleTransition  string
+}
+
+// isLaunch reports whether the event represents an instance joining (rather
+// than leaving) the ASG, covering both the legacy SNS notification shape and
+// the LifecycleTransition carried by lifecycle-hook messages.
+func (event autoscalingEvent) isLaunch() bool {
+	if event.LifecycleTransition != "" {
+		return event.LifecycleTransition == "autoscaling:EC2_INSTANCE_LAUNCHING"
+	}
+	return event.Event == "autoscaling:EC2_INSTANCE_LAUNCH"
 }
 
 // New Create a new reactor to ASG SNS Events
@@ -42,14 +78,40 @@ func New(sess *session.Session) Reactor {
 		})
 	}
 
+	return NewWithClients(route53.New(sess), autoscaling.New(sess), ec2.New(sess))
+}
+
+// NewWithClients creates a Reactor from already-constructed AWS clients, or
+// fakes satisfying the same minimal interfaces, letting tests and alternative
+// backends substitute their own implementations.
+func NewWithClients(route53Client route53API, autoscalingClient autoscalingAPI, ec2Client ec2API) Reactor {
 	return Reactor{
-		route53Client:     route53.New(sess),
-		autoscalingClient: autoscaling.New(sess),
-		ec2Client:         ec2.New(sess),
+		route53Client:     route53Client,
+		autoscalingClient: autoscalingClient,
+		ec2Client:         ec2Client,
 	}
 }
 
+// processEvent updates Route53 for a single ASG event and, for lifecycle-hook
+// deliveries, reports the outcome back to the ASG so the hook doesn't block
+// the instance on CONTINUE or, on error, ABANDON.
 func (r Reactor) processEvent(event autoscalingEvent) (*string, error) {
+	result, err := r.updateDNS(event)
+
+	if event.LifecycleActionToken != "" {
+		lifecycleResult := "CONTINUE"
+		if err != nil {
+			lifecycleResult = "ABANDON"
+		}
+		if completeErr := r.completeLifecycleAction(event, lifecycleResult); completeErr != nil && err == nil {
+			return result, completeErr
+		}
+	}
+
+	return result, err
+}
+
+func (r Reactor) updateDNS(event autoscalingEvent) (*string, error) {
 	asg, err := r.lookupAutoScalingGroup(event.AutoScalingGroupName)
 	if err != nil {
 		return nil, err
@@ -65,33 +127,346 @@ func (r Reactor) processEvent(event autoscalingEvent) (*string, error) {
 		return nil, err
 	}
 
-	hostname, _ := identity.GenerateHostname(*instance)
-	fqdn := strings.Join([]string{*hostname, *zone.HostedZone.Name}, ".")
+	if instance.PrivateIpAddress == nil {
+		// GenerateHostname derives the hostname from the private IP
+		// regardless of which address tag selects the published record, so
+		// an instance without one (e.g. still propagating, or IPv6-only)
+		// can't be named yet - fail the same way instanceAddress does for a
+		// missing public/IPv6 address rather than panicking.
+		return nil, errors.New("instance has no private IP address")
+	}
+	hostname, err := identity.GenerateHostname(*instance)
+	if err != nil {
+		return nil, err
+	}
+	zoneName := *zone.HostedZone.Name
+	fqdn := strings.Join([]string{*hostname, zoneName}, ".")
+	upsert := event.isLaunch()
 
-	change := route53.Change{
-		Action: aws.String("UPSERT"),
-		ResourceRecordSet: &route53.ResourceRecordSet{
-			Name:            aws.String(fqdn),
-			Type:            aws.String("A"),
-			ResourceRecords: []*route53.ResourceRecord{&route53.ResourceRecord{Value: aws.String(*instance.PrivateIpAddress)}},
-			TTL:             aws.Int64(60),
+	changes, err := r.addressRecordChanges(asg, instance, fqdn, upsert)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceChanges, err := r.serviceDiscoveryChanges(asg, zoneID, *hostname, zoneName, fqdn, upsert)
+	if err != nil {
+		return nil, err
+	}
+	changes = append(changes, serviceChanges...)
+
+	if err := r.submitChanges(zoneID, changes); err != nil {
+		return nil, err
+	}
+
+	return &event.EC2InstanceID, nil
+}
+
+// route53ChangeBatchLimit is the maximum number of Changes Route53 accepts
+// in a single ChangeResourceRecordSets call.
+const route53ChangeBatchLimit = 100
+
+// submitChanges applies changes to the zone, splitting them into batches of
+// at most route53ChangeBatchLimit as Route53 requires.
+func (r Reactor) submitChanges(zoneID *string, changes []*route53.Change) error {
+	for len(changes) > 0 {
+		batchSize := route53ChangeBatchLimit
+		if batchSize > len(changes) {
+			batchSize = len(changes)
+		}
+		batch := changes[:batchSize]
+		changes = changes[batchSize:]
+
+		_, err := r.route53Client.ChangeResourceRecordSets(&route53.ChangeResourceRecordSetsInput{
+			HostedZoneId: zoneID,
+			ChangeBatch:  &route53.ChangeBatch{Changes: batch},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const (
+	defaultTTL        = 60
+	defaultRecordType = "A"
+	defaultAddress    = "private"
+)
+
+// addressRecordChanges builds the A and/or AAAA record changes for an
+// instance, driven by the massive:DNS-SD:Route53:ttl, :recordType and
+// :address tags. Defaults (A, 60s TTL, private IPv4) apply when a tag is
+// absent, matching the reactor's pre-existing behaviour.
+func (r Reactor) addressRecordChanges(asg *autoscaling.Group, instance *ec2.Instance, fqdn string, upsert bool) ([]*route53.Change, error) {
+	ttl := int64(intTag(extractTag("massive:DNS-SD:Route53:ttl", asg.Tags), defaultTTL))
+	recordType := stringTag(extractTag("massive:DNS-SD:Route53:recordType", asg.Tags), defaultRecordType)
+	address := stringTag(extractTag("massive:DNS-SD:Route53:address", asg.Tags), defaultAddress)
+
+	recordTypes := []string{recordType}
+	if recordType == "both" {
+		recordTypes = []string{"A", "AAAA"}
+	}
+
+	changes := make([]*route53.Change, 0, len(recordTypes))
+	for _, recordType := range recordTypes {
+		addressMode := address
+		if recordType == "AAAA" {
+			addressMode = "ipv6"
+		} else if addressMode == "ipv6" {
+			addressMode = defaultAddress
+		}
+
+		ip, err := instanceAddress(instance, addressMode)
+		if err != nil {
+			return nil, err
+		}
+
+		changes = append(changes, &route53.Change{
+			Action: aws.String(changeAction(upsert)),
+			ResourceRecordSet: &route53.ResourceRecordSet{
+				Name:            aws.String(fqdn),
+				Type:            aws.String(recordType),
+				ResourceRecords: []*route53.ResourceRecord{{Value: aws.String(ip)}},
+				TTL:             aws.Int64(ttl),
+			},
+		})
+	}
+
+	return changes, nil
+}
+
+// instanceAddress resolves the IP address to publish for an instance under
+// addressMode ("private", "public", or "ipv6").
+func instanceAddress(instance *ec2.Instance, addressMode string) (string, error) {
+	switch addressMode {
+	case "public":
+		if instance.PublicIpAddress == nil {
+			return "", errors.New("instance has no public IP address")
+		}
+		return *instance.PublicIpAddress, nil
+	case "ipv6":
+		return instanceIPv6Address(instance)
+	default:
+		if instance.PrivateIpAddress == nil {
+			return "", errors.New("instance has no private IP address")
+		}
+		return *instance.PrivateIpAddress, nil
+	}
+}
+
+// instanceIPv6Address returns the first IPv6 address on an instance's
+// primary network interface (device index 0), or on the first listed
+// interface when none reports device index 0.
+func instanceIPv6Address(instance *ec2.Instance) (string, error) {
+	var primary *ec2.InstanceNetworkInterface
+	for _, iface := range instance.NetworkInterfaces {
+		if iface.Attachment != nil && iface.Attachment.DeviceIndex != nil && *iface.Attachment.DeviceIndex == 0 {
+			primary = iface
+			break
+		}
+	}
+	if primary == nil && len(instance.NetworkInterfaces) > 0 {
+		primary = instance.NetworkInterfaces[0]
+	}
+	if primary == nil || len(primary.Ipv6Addresses) == 0 {
+		return "", errors.New("instance has no IPv6 address on its primary network interface")
+	}
+	return *primary.Ipv6Addresses[0].Ipv6Address, nil
+}
+
+// completeLifecycleAction tells the ASG the lifecycle hook for event is
+// done, with result being "CONTINUE" or "ABANDON".
+func (r Reactor) completeLifecycleAction(event autoscalingEvent, result string) error {
+	_, err := r.autoscalingClient.CompleteLifecycleAction(&autoscaling.CompleteLifecycleActionInput{
+		AutoScalingGroupName:  aws.String(event.AutoScalingGroupName),
+		LifecycleHookName:     aws.String(event.LifecycleHookName),
+		LifecycleActionToken:  aws.String(event.LifecycleActionToken),
+		LifecycleActionResult: aws.String(result),
+		InstanceId:            aws.String(event.EC2InstanceID),
+	})
+	return err
+}
+
+// RecordLifecycleActionHeartbeat extends the timeout of an in-progress
+// lifecycle action, for callers that need to wait longer than the hook's
+// configured timeout for DNS changes to propagate.
+func (r Reactor) RecordLifecycleActionHeartbeat(event autoscalingEvent) error {
+	_, err := r.autoscalingClient.RecordLifecycleActionHeartbeat(&autoscaling.RecordLifecycleActionHeartbeatInput{
+		AutoScalingGroupName: aws.String(event.AutoScalingGroupName),
+		LifecycleHookName:    aws.String(event.LifecycleHookName),
+		LifecycleActionToken: aws.String(event.LifecycleActionToken),
+		InstanceId:           aws.String(event.EC2InstanceID),
+	})
+	return err
+}
+
+// serviceDiscoveryChanges builds the RFC 6763 DNS-SD records (SRV, PTR, TXT)
+// for an instance, driven by the massive:DNS-SD:service tag family. It
+// returns no changes when the ASG hasn't opted into service discovery.
+func (r Reactor) serviceDiscoveryChanges(asg *autoscaling.Group, zoneID *string, instanceName, zoneName, fqdn string, upsert bool) ([]*route53.Change, error) {
+	service := extractTag("massive:DNS-SD:service", asg.Tags)
+	if service == nil {
+		return nil, nil
+	}
+
+	srvName := strings.Join([]string{instanceName, *service, zoneName}, ".")
+	ptrName := strings.Join([]string{*service, zoneName}, ".")
+
+	priority := intTag(extractTag("massive:DNS-SD:priority", asg.Tags), 0)
+	weight := intTag(extractTag("massive:DNS-SD:weight", asg.Tags), 0)
+	port := intTag(extractTag("massive:DNS-SD:port", asg.Tags), 0)
+	srvValue := fmt.Sprintf("%d %d %d %s", priority, weight, port, fqdn)
+
+	changes := []*route53.Change{
+		{
+			Action: aws.String(changeAction(upsert)),
+			ResourceRecordSet: &route53.ResourceRecordSet{
+				Name:            aws.String(srvName),
+				Type:            aws.String("SRV"),
+				ResourceRecords: []*route53.ResourceRecord{{Value: aws.String(srvValue)}},
+				TTL:             aws.Int64(60),
+			},
 		},
 	}
 
-	if event.Event != "autoscaling:EC2_INSTANCE_LAUNCH" {
-		change.SetAction("DELETE")
+	if txt := extractTag("massive:DNS-SD:txt", asg.Tags); txt != nil {
+		changes = append(changes, &route53.Change{
+			Action: aws.String(changeAction(upsert)),
+			ResourceRecordSet: &route53.ResourceRecordSet{
+				Name:            aws.String(srvName),
+				Type:            aws.String("TXT"),
+				ResourceRecords: []*route53.ResourceRecord{{Value: aws.String(quoteTxtValue(*txt))}},
+				TTL:             aws.Int64(60),
+			},
+		})
+	}
+
+	ptrChange, err := r.ptrChange(zoneID, ptrName, srvName, upsert)
+	if err != nil {
+		return nil, err
+	}
+	if ptrChange != nil {
+		changes = append(changes, ptrChange)
+	}
+
+	return changes, nil
+}
+
+// ptrChange reconciles the shared PTR RRSet for a service with a single
+// instance joining or leaving it. Route53 has no append/subtract semantics
+// for a RRSet, so this does a GET-modify-UPSERT (or DELETE, if the instance
+// being removed was the last one) against the current records.
+func (r Reactor) ptrChange(zoneID *string, ptrName, srvName string, upsert bool) (*route53.Change, error) {
+	existing, err := r.getResourceRecordSet(zoneID, ptrName, "PTR")
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]bool{}
+	if existing != nil {
+		for _, record := range existing.ResourceRecords {
+			values[*record.Value] = true
+		}
+	}
+
+	if upsert {
+		if values[srvName] {
+			return nil, nil
+		}
+		values[srvName] = true
+	} else {
+		if !values[srvName] {
+			return nil, nil
+		}
+		delete(values, srvName)
+	}
+
+	if len(values) == 0 {
+		return &route53.Change{
+			Action:            aws.String("DELETE"),
+			ResourceRecordSet: existing,
+		}, nil
+	}
+
+	records := make([]*route53.ResourceRecord, 0, len(values))
+	for value := range values {
+		records = append(records, &route53.ResourceRecord{Value: aws.String(value)})
 	}
 
-	_, err = r.route53Client.ChangeResourceRecordSets(&route53.ChangeResourceRecordSetsInput{
-		ChangeBatch: &route53.ChangeBatch{
-			Changes: []*route53.Change{&change},
+	return &route53.Change{
+		Action: aws.String("UPSERT"),
+		ResourceRecordSet: &route53.ResourceRecordSet{
+			Name:            aws.String(ptrName),
+			Type:            aws.String("PTR"),
+			ResourceRecords: records,
+			TTL:             aws.Int64(60),
 		},
+	}, nil
+}
+
+// getResourceRecordSet returns the current RRSet for name/recordType, or nil
+// if no such RRSet exists yet.
+func (r Reactor) getResourceRecordSet(zoneID *string, name, recordType string) (*route53.ResourceRecordSet, error) {
+	output, err := r.route53Client.ListResourceRecordSets(&route53.ListResourceRecordSetsInput{
+		HostedZoneId:    zoneID,
+		StartRecordName: aws.String(name),
+		StartRecordType: aws.String(recordType),
+		MaxItems:        aws.String("1"),
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	return &event.EC2InstanceID, nil
+	if len(output.ResourceRecordSets) == 0 {
+		return nil, nil
+	}
+
+	rrset := output.ResourceRecordSets[0]
+	if *rrset.Name != name || *rrset.Type != recordType {
+		return nil, nil
+	}
+	return rrset, nil
+}
+
+func changeAction(upsert bool) string {
+	if upsert {
+		return "UPSERT"
+	}
+	return "DELETE"
+}
+
+// quoteTxtValue turns a comma-separated massive:DNS-SD:txt tag value (e.g.
+// "version=1,role=leader") into the space-separated, double-quoted string
+// list Route53 expects for TXT record values.
+func quoteTxtValue(tag string) string {
+	pairs := strings.Split(tag, ",")
+	quoted := make([]string, 0, len(pairs))
+	for _, pair := range pairs {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		quoted = append(quoted, strconv.Quote(pair))
+	}
+	return strings.Join(quoted, " ")
+}
+
+func stringTag(tag *string, fallback string) string {
+	if tag == nil {
+		return fallback
+	}
+	return *tag
+}
+
+func intTag(tag *string, fallback int) int {
+	if tag == nil {
+		return fallback
+	}
+	value, err := strconv.Atoi(*tag)
+	if err != nil {
+		return fallback
+	}
+	return value
 }
 
 func (r Reactor) getInstance(InstanceID string) (*ec2.Instance, error) {
@@ -127,19 +502,351 @@ func (r Reactor) lookupAutoScalingGroup(name string) (*autoscaling.Group, error)
 	return output.AutoScalingGroups[0], nil
 }
 
-//Handle a request
-func (r Reactor) Handle(req events.SNSEvent) (*string, error) {
-	if len(req.Records) == 0 || req.Records[0].SNS.Message == "" {
-		return nil, errors.New("No SNS Message found")
+// Reconcile sweeps the hosted zone configured for asgName and repairs drift
+// against live ASG membership: it UPSERTs address records for InService
+// instances that are missing them or whose IP no longer matches, and DELETEs
+// generated-hostname records no longer backed by a live instance, recovering from LAUNCH/TERMINATE notifications that were dropped
+// or errored out. Intended to be run periodically, e.g. from an EventBridge
+// cron rule via HandleReconcile.
+func (r Reactor) Reconcile(ctx context.Context, asgName string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	asg, err := r.lookupAutoScalingGroup(asgName)
+	if err != nil {
+		return err
+	}
+
+	zoneID := extractTag("massive:DNS-SD:Route53:zone", asg.Tags)
+	zone, err := r.route53Client.GetHostedZone(&route53.GetHostedZoneInput{Id: zoneID})
+	if err != nil {
+		return err
+	}
+	zoneName := *zone.HostedZone.Name
+
+	instances, err := r.inServiceInstances(asg)
+	if err != nil {
+		return err
+	}
+
+	recordType := stringTag(extractTag("massive:DNS-SD:Route53:recordType", asg.Tags), defaultRecordType)
+	address := stringTag(extractTag("massive:DNS-SD:Route53:address", asg.Tags), defaultAddress)
+	ttl := int64(intTag(extractTag("massive:DNS-SD:Route53:ttl", asg.Tags), defaultTTL))
+	recordTypes := []string{recordType}
+	if recordType == "both" {
+		recordTypes = []string{"A", "AAAA"}
+	}
+
+	wanted := map[string]map[string]string{} // fqdn -> record type -> IP
+	unresolved := map[string]map[string]bool{}
+	var unresolvedErrs []string
+	for _, instance := range instances {
+		if instance.PrivateIpAddress == nil {
+			// GenerateHostname derives the hostname from the private IP
+			// regardless of which address is actually published, so an
+			// instance whose private IP hasn't propagated yet can't be
+			// named this sweep at all. It is reported in the returned error;
+			// with no fqdn to key off of, it is not marked unresolved below,
+			// so an existing record of its own is not recognised as such and
+			// is DELETEd like any other record with no live instance behind it.
+			unresolvedErrs = append(unresolvedErrs, fmt.Sprintf("%s: instance has no private IP address, cannot derive its hostname", aws.StringValue(instance.InstanceId)))
+			continue
+		}
+		hostname, err := identity.GenerateHostname(*instance)
+		if err != nil {
+			unresolvedErrs = append(unresolvedErrs, fmt.Sprintf("%s: %v", aws.StringValue(instance.InstanceId), err))
+			continue
+		}
+		fqdn := strings.Join([]string{*hostname, zoneName}, ".")
+
+		wanted[fqdn] = map[string]string{}
+		for _, t := range recordTypes {
+			addressMode := address
+			if t == "AAAA" {
+				addressMode = "ipv6"
+			} else if addressMode == "ipv6" {
+				addressMode = defaultAddress
+			}
+			ip, err := instanceAddress(instance, addressMode)
+			if err != nil {
+				// Leave any existing record for this instance/type alone rather
+				// than treating the failed read as "instance doesn't want this
+				// record" - that would turn a transient DescribeInstances hiccup
+				// into a DELETE of an otherwise-correct record.
+				if unresolved[fqdn] == nil {
+					unresolved[fqdn] = map[string]bool{}
+				}
+				unresolved[fqdn][t] = true
+				unresolvedErrs = append(unresolvedErrs, fmt.Sprintf("%s %s: %v", aws.StringValue(instance.InstanceId), t, err))
+				continue
+			}
+			wanted[fqdn][t] = ip
+		}
+	}
+
+	existing, err := r.listInstanceRecordSets(zoneID, zoneName, recordTypes)
+	if err != nil {
+		return err
+	}
+
+	var changes []*route53.Change
+	found := map[string]map[string]bool{}
+	for _, rrset := range existing {
+		name, recordType := *rrset.Name, *rrset.Type
+		if found[name] == nil {
+			found[name] = map[string]bool{}
+		}
+		found[name][recordType] = true
+
+		if unresolved[name][recordType] {
+			continue
+		}
+
+		wantIP, stillPresent := wanted[name][recordType]
+		haveIP := len(rrset.ResourceRecords) > 0 && *rrset.ResourceRecords[0].Value == wantIP
+		if stillPresent && haveIP {
+			continue
+		}
+
+		if !stillPresent {
+			changes = append(changes, &route53.Change{Action: aws.String("DELETE"), ResourceRecordSet: rrset})
+			continue
+		}
+
+		changes = append(changes, &route53.Change{
+			Action: aws.String("UPSERT"),
+			ResourceRecordSet: &route53.ResourceRecordSet{
+				Name:            aws.String(name),
+				Type:            aws.String(recordType),
+				ResourceRecords: []*route53.ResourceRecord{{Value: aws.String(wantIP)}},
+				TTL:             aws.Int64(ttl),
+			},
+		})
+	}
+
+	for fqdn, byType := range wanted {
+		for recordType, ip := range byType {
+			if found[fqdn][recordType] {
+				continue
+			}
+			changes = append(changes, &route53.Change{
+				Action: aws.String("UPSERT"),
+				ResourceRecordSet: &route53.ResourceRecordSet{
+					Name:            aws.String(fqdn),
+					Type:            aws.String(recordType),
+					ResourceRecords: []*route53.ResourceRecord{{Value: aws.String(ip)}},
+					TTL:             aws.Int64(ttl),
+				},
+			})
+		}
+	}
+
+	if err := r.submitChanges(zoneID, changes); err != nil {
+		return err
+	}
+
+	if len(unresolvedErrs) > 0 {
+		return fmt.Errorf("reconcile left %d record(s) untouched after failing to resolve their address: %s", len(unresolvedErrs), strings.Join(unresolvedErrs, "; "))
+	}
+	return nil
+}
+
+// inServiceInstances returns the EC2 instances backing asg's InService
+// members.
+func (r Reactor) inServiceInstances(asg *autoscaling.Group) ([]*ec2.Instance, error) {
+	var ids []*string
+	for _, instance := range asg.Instances {
+		if instance.LifecycleState != nil && *instance.LifecycleState == autoscaling.LifecycleStateInService {
+			ids = append(ids, instance.InstanceId)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
 	}
 
-	message := req.Records[0].SNS.Message
-	var evt autoscalingEvent
-	err := json.Unmarshal([]byte(message), &evt)
+	output, err := r.ec2Client.DescribeInstances(&ec2.DescribeInstancesInput{InstanceIds: ids})
 	if err != nil {
 		return nil, err
 	}
-	return r.processEvent(evt)
+
+	var instances []*ec2.Instance
+	for _, reservation := range output.Reservations {
+		instances = append(instances, reservation.Instances...)
+	}
+	return instances, nil
+}
+
+// instanceHostnameLabel matches the shape identity.GenerateHostname produces
+// for an instance record: an optional name prefix followed by the
+// instance's IP address with dots replaced by dashes, AWS's own "ip-10-0-0-5"
+// convention. A hand-managed record sitting at the same depth (e.g. "www" or
+// "vpn") won't match this shape, so Reconcile won't mistake it for drift.
+var instanceHostnameLabel = regexp.MustCompile(`^(?:[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?-)?ip-\d{1,3}-\d{1,3}-\d{1,3}-\d{1,3}$`)
+
+// listInstanceRecordSets returns every RRSet of the given record types in
+// the zone whose name matches the identity.GenerateHostname pattern. This
+// excludes both the SRV/PTR/TXT records serviceDiscoveryChanges manages and
+// any unrelated, manually-managed A/AAAA records that happen to share the
+// same depth under the zone apex.
+func (r Reactor) listInstanceRecordSets(zoneID *string, zoneName string, recordTypes []string) ([]*route53.ResourceRecordSet, error) {
+	wantType := map[string]bool{}
+	for _, t := range recordTypes {
+		wantType[t] = true
+	}
+	wantDepth := labelCount(zoneName) + 1
+
+	var matches []*route53.ResourceRecordSet
+	input := &route53.ListResourceRecordSetsInput{HostedZoneId: zoneID}
+	for {
+		output, err := r.route53Client.ListResourceRecordSets(input)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, rrset := range output.ResourceRecordSets {
+			if wantType[*rrset.Type] && labelCount(*rrset.Name) == wantDepth && isInstanceHostname(*rrset.Name) {
+				matches = append(matches, rrset)
+			}
+		}
+
+		if !aws.BoolValue(output.IsTruncated) {
+			return matches, nil
+		}
+		input = &route53.ListResourceRecordSetsInput{
+			HostedZoneId:    zoneID,
+			StartRecordName: output.NextRecordName,
+			StartRecordType: output.NextRecordType,
+		}
+	}
+}
+
+func isInstanceHostname(name string) bool {
+	label := strings.SplitN(name, ".", 2)[0]
+	return instanceHostnameLabel.MatchString(label)
+}
+
+func labelCount(name string) int {
+	return len(strings.Split(strings.TrimSuffix(name, "."), "."))
+}
+
+// reconcileEvent is the payload for a scheduled drift-reconciliation sweep,
+// e.g. from an EventBridge cron rule.
+type reconcileEvent struct {
+	AutoScalingGroupName string
+}
+
+// HandleReconcile is the Lambda entrypoint for a scheduled drift
+// reconciliation sweep (see Reconcile), wired up independently of the
+// LAUNCH/TERMINATE notification path.
+func (r Reactor) HandleReconcile(ctx context.Context, event reconcileEvent) error {
+	return r.Reconcile(ctx, event.AutoScalingGroupName)
+}
+
+// HandleResult is the outcome of processing a single SNS record.
+type HandleResult struct {
+	InstanceID *string
+	Err        error
+}
+
+//Handle a request, which may batch several notifications in a single SNS delivery
+func (r Reactor) Handle(req events.SNSEvent) ([]HandleResult, error) {
+	if len(req.Records) == 0 {
+		return nil, errors.New("No SNS records found")
+	}
+
+	results := make([]HandleResult, 0, len(req.Records))
+	for _, record := range req.Records {
+		if record.SNS.Message == "" {
+			results = append(results, HandleResult{Err: errors.New("No SNS Message found")})
+			continue
+		}
+
+		var evt autoscalingEvent
+		if err := json.Unmarshal([]byte(record.SNS.Message), &evt); err != nil {
+			results = append(results, HandleResult{Err: err})
+			continue
+		}
+
+		instanceID, err := r.processEvent(evt)
+		results = append(results, HandleResult{InstanceID: instanceID, Err: err})
+	}
+
+	return results, nil
+}
+
+// eventBridgeDetail mirrors the subset of the ASG EventBridge notification
+// "detail" payload needed to drive processEvent, covering both plain
+// lifecycle-transition events and lifecycle-hook events.
+type eventBridgeDetail struct {
+	EC2InstanceID        string `json:"EC2InstanceId"`
+	AutoScalingGroupName string `json:"AutoScalingGroupName"`
+	LifecycleHookName    string `json:"LifecycleHookName"`
+	LifecycleActionToken string `json:"LifecycleActionToken"`
+	LifecycleTransition  string `json:"LifecycleTransition"`
+}
+
+// eventBridgeDetailTypeEvent maps an EventBridge detail-type to the legacy
+// SNS Event string processEvent understands.
+var eventBridgeDetailTypeEvent = map[string]string{
+	"EC2 Instance Launch Successful":    "autoscaling:EC2_INSTANCE_LAUNCH",
+	"EC2 Instance Terminate Successful": "autoscaling:EC2_INSTANCE_TERMINATE",
+}
+
+// HandleEventBridge processes a single ASG notification delivered via
+// EventBridge/CloudWatch Events, the delivery mechanism AWS now recommends
+// in place of an SNS topic.
+func (r Reactor) HandleEventBridge(evt events.CloudWatchEvent) (*string, error) {
+	var detail eventBridgeDetail
+	if err := json.Unmarshal(evt.Detail, &detail); err != nil {
+		return nil, err
+	}
+
+	event, ok := eventBridgeDetailTypeEvent[evt.DetailType]
+	if !ok && detail.LifecycleTransition == "" {
+		// Lifecycle-hook detail-types aren't in the map because isLaunch can
+		// resolve them from LifecycleTransition directly; anything else
+		// unmapped is a detail-type we don't understand, e.g. an
+		// "Unsuccessful" notification. Don't let that silently default to
+		// Event == "", which isLaunch reads as a TERMINATE/DELETE.
+		return nil, fmt.Errorf("unrecognized EventBridge detail-type %q", evt.DetailType)
+	}
+
+	return r.processEvent(autoscalingEvent{
+		EC2InstanceID:        detail.EC2InstanceID,
+		AutoScalingGroupName: detail.AutoScalingGroupName,
+		Event:                event,
+		LifecycleHookName:    detail.LifecycleHookName,
+		LifecycleActionToken: detail.LifecycleActionToken,
+		LifecycleTransition:  detail.LifecycleTransition,
+	})
+}
+
+// HandleAny dispatches a raw Lambda event payload to the SNS or EventBridge
+// handler depending on its envelope shape, so the Lambda can be wired to
+// either delivery mechanism without the caller needing to know which.
+func (r Reactor) HandleAny(payload json.RawMessage) ([]HandleResult, error) {
+	var envelope struct {
+		Source string `json:"source"`
+	}
+	if err := json.Unmarshal(payload, &envelope); err != nil {
+		return nil, err
+	}
+
+	if envelope.Source == "aws.autoscaling" {
+		var cwEvent events.CloudWatchEvent
+		if err := json.Unmarshal(payload, &cwEvent); err != nil {
+			return nil, err
+		}
+		instanceID, err := r.HandleEventBridge(cwEvent)
+		return []HandleResult{{InstanceID: instanceID, Err: err}}, nil
+	}
+
+	var snsEvent events.SNSEvent
+	if err := json.Unmarshal(payload, &snsEvent); err != nil {
+		return nil, err
+	}
+	return r.Handle(snsEvent)
 }
 
 func extractTag(tagName string, tags []*autoscaling.TagDescription) *string {
@@ -0,0 +1,432 @@
+package reactor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+type fakeRoute53 struct {
+	zoneName  string
+	rrsets    map[string]*route53.ResourceRecordSet
+	list      []*route53.ResourceRecordSet
+	changes   []*route53.Change
+	changeErr error
+}
+
+func (f *fakeRoute53) GetHostedZone(input *route53.GetHostedZoneInput) (*route53.GetHostedZoneOutput, error) {
+	if input.Id == nil {
+		return nil, errors.New("missing hosted zone id")
+	}
+	return &route53.GetHostedZoneOutput{
+		HostedZone: &route53.HostedZone{Id: input.Id, Name: aws.String(f.zoneName)},
+	}, nil
+}
+
+func (f *fakeRoute53) ListResourceRecordSets(input *route53.ListResourceRecordSetsInput) (*route53.ListResourceRecordSetsOutput, error) {
+	if input.StartRecordName == nil {
+		return &route53.ListResourceRecordSetsOutput{ResourceRecordSets: f.list}, nil
+	}
+
+	key := *input.StartRecordName + "|" + *input.StartRecordType
+	if rrset, ok := f.rrsets[key]; ok {
+		return &route53.ListResourceRecordSetsOutput{ResourceRecordSets: []*route53.ResourceRecordSet{rrset}}, nil
+	}
+	return &route53.ListResourceRecordSetsOutput{}, nil
+}
+
+func (f *fakeRoute53) ChangeResourceRecordSets(input *route53.ChangeResourceRecordSetsInput) (*route53.ChangeResourceRecordSetsOutput, error) {
+	if f.changeErr != nil {
+		return nil, f.changeErr
+	}
+	f.changes = append(f.changes, input.ChangeBatch.Changes...)
+	return &route53.ChangeResourceRecordSetsOutput{}, nil
+}
+
+type fakeAutoscaling struct {
+	group *autoscaling.Group
+}
+
+func (f *fakeAutoscaling) DescribeAutoScalingGroups(*autoscaling.DescribeAutoScalingGroupsInput) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
+	if f.group == nil {
+		return &autoscaling.DescribeAutoScalingGroupsOutput{}, nil
+	}
+	return &autoscaling.DescribeAutoScalingGroupsOutput{AutoScalingGroups: []*autoscaling.Group{f.group}}, nil
+}
+
+func (f *fakeAutoscaling) CompleteLifecycleAction(*autoscaling.CompleteLifecycleActionInput) (*autoscaling.CompleteLifecycleActionOutput, error) {
+	return &autoscaling.CompleteLifecycleActionOutput{}, nil
+}
+
+func (f *fakeAutoscaling) RecordLifecycleActionHeartbeat(*autoscaling.RecordLifecycleActionHeartbeatInput) (*autoscaling.RecordLifecycleActionHeartbeatOutput, error) {
+	return &autoscaling.RecordLifecycleActionHeartbeatOutput{}, nil
+}
+
+type fakeEC2 struct {
+	instance  *ec2.Instance
+	instances map[string]*ec2.Instance
+}
+
+func (f *fakeEC2) DescribeInstances(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	var instances []*ec2.Instance
+	if len(input.InstanceIds) > 0 {
+		for _, id := range input.InstanceIds {
+			if instance, ok := f.instances[*id]; ok {
+				instances = append(instances, instance)
+			}
+		}
+	} else if f.instance != nil {
+		instances = []*ec2.Instance{f.instance}
+	}
+
+	if len(instances) == 0 {
+		return &ec2.DescribeInstancesOutput{}, nil
+	}
+	return &ec2.DescribeInstancesOutput{
+		Reservations: []*ec2.Reservation{{Instances: instances}},
+	}, nil
+}
+
+func TestProcessEvent(t *testing.T) {
+	instance := &ec2.Instance{
+		InstanceId:       aws.String("i-0123456789abcdef0"),
+		PrivateIpAddress: aws.String("10.0.0.5"),
+	}
+
+	groupWithZone := &autoscaling.Group{
+		Tags: []*autoscaling.TagDescription{
+			{Key: aws.String("massive:DNS-SD:Route53:zone"), Value: aws.String("Z123456")},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		event      autoscalingEvent
+		group      *autoscaling.Group
+		instance   *ec2.Instance
+		changeErr  error
+		wantErr    bool
+		wantAction string
+	}{
+		{
+			name:       "launch upserts the A record",
+			event:      autoscalingEvent{EC2InstanceID: "i-0123456789abcdef0", AutoScalingGroupName: "my-asg", Event: "autoscaling:EC2_INSTANCE_LAUNCH"},
+			group:      groupWithZone,
+			wantAction: "UPSERT",
+		},
+		{
+			name:       "terminate deletes the A record",
+			event:      autoscalingEvent{EC2InstanceID: "i-0123456789abcdef0", AutoScalingGroupName: "my-asg", Event: "autoscaling:EC2_INSTANCE_TERMINATE"},
+			group:      groupWithZone,
+			wantAction: "DELETE",
+		},
+		{
+			name:    "missing zone tag fails",
+			event:   autoscalingEvent{EC2InstanceID: "i-0123456789abcdef0", AutoScalingGroupName: "my-asg", Event: "autoscaling:EC2_INSTANCE_LAUNCH"},
+			group:   &autoscaling.Group{},
+			wantErr: true,
+		},
+		{
+			name:      "ChangeResourceRecordSets error is surfaced",
+			event:     autoscalingEvent{EC2InstanceID: "i-0123456789abcdef0", AutoScalingGroupName: "my-asg", Event: "autoscaling:EC2_INSTANCE_LAUNCH"},
+			group:     groupWithZone,
+			changeErr: errors.New("boom"),
+			wantErr:   true,
+		},
+		{
+			name:     "instance without a private IP fails instead of panicking",
+			event:    autoscalingEvent{EC2InstanceID: "i-no-private-ip", AutoScalingGroupName: "my-asg", Event: "autoscaling:EC2_INSTANCE_LAUNCH"},
+			group:    groupWithZone,
+			instance: &ec2.Instance{InstanceId: aws.String("i-no-private-ip"), PublicIpAddress: aws.String("203.0.113.9")},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useInstance := tt.instance
+			if useInstance == nil {
+				useInstance = instance
+			}
+			r53 := &fakeRoute53{zoneName: "example.com.", rrsets: map[string]*route53.ResourceRecordSet{}, changeErr: tt.changeErr}
+			reactor := NewWithClients(r53, &fakeAutoscaling{group: tt.group}, &fakeEC2{instance: useInstance})
+
+			instanceID, err := reactor.processEvent(tt.event)
+
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if instanceID == nil || *instanceID != tt.event.EC2InstanceID {
+				t.Fatalf("expected instance ID %q, got %v", tt.event.EC2InstanceID, instanceID)
+			}
+			if len(r53.changes) == 0 {
+				t.Fatalf("expected at least one Route53 change")
+			}
+			if *r53.changes[0].Action != tt.wantAction {
+				t.Fatalf("expected action %q, got %q", tt.wantAction, *r53.changes[0].Action)
+			}
+		})
+	}
+}
+
+func TestAddressRecordChanges(t *testing.T) {
+	instance := &ec2.Instance{
+		PrivateIpAddress: aws.String("10.0.0.5"),
+		PublicIpAddress:  aws.String("203.0.113.5"),
+		NetworkInterfaces: []*ec2.InstanceNetworkInterface{
+			{
+				Attachment:    &ec2.InstanceNetworkInterfaceAttachment{DeviceIndex: aws.Int64(0)},
+				Ipv6Addresses: []*ec2.InstanceIpv6Address{{Ipv6Address: aws.String("2001:db8::1")}},
+			},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		tags      []*autoscaling.TagDescription
+		wantTypes []string
+		wantIPs   []string
+	}{
+		{
+			name:      "defaults to a single private A record at 60s",
+			tags:      nil,
+			wantTypes: []string{"A"},
+			wantIPs:   []string{"10.0.0.5"},
+		},
+		{
+			name: "public address tag selects the public IP",
+			tags: []*autoscaling.TagDescription{
+				{Key: aws.String("massive:DNS-SD:Route53:address"), Value: aws.String("public")},
+			},
+			wantTypes: []string{"A"},
+			wantIPs:   []string{"203.0.113.5"},
+		},
+		{
+			name: "both emits an A and an AAAA record",
+			tags: []*autoscaling.TagDescription{
+				{Key: aws.String("massive:DNS-SD:Route53:recordType"), Value: aws.String("both")},
+			},
+			wantTypes: []string{"A", "AAAA"},
+			wantIPs:   []string{"10.0.0.5", "2001:db8::1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reactor := Reactor{}
+			asg := &autoscaling.Group{Tags: tt.tags}
+
+			changes, err := reactor.addressRecordChanges(asg, instance, "i-0123.example.com.", true)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(changes) != len(tt.wantTypes) {
+				t.Fatalf("expected %d changes, got %d", len(tt.wantTypes), len(changes))
+			}
+			for i, change := range changes {
+				if *change.ResourceRecordSet.Type != tt.wantTypes[i] {
+					t.Fatalf("change %d: expected type %q, got %q", i, tt.wantTypes[i], *change.ResourceRecordSet.Type)
+				}
+				if *change.ResourceRecordSet.ResourceRecords[0].Value != tt.wantIPs[i] {
+					t.Fatalf("change %d: expected IP %q, got %q", i, tt.wantIPs[i], *change.ResourceRecordSet.ResourceRecords[0].Value)
+				}
+				if *change.ResourceRecordSet.TTL != defaultTTL {
+					t.Fatalf("change %d: expected TTL %d, got %d", i, defaultTTL, *change.ResourceRecordSet.TTL)
+				}
+			}
+		})
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	liveInstance := &ec2.Instance{
+		InstanceId:       aws.String("i-live"),
+		PrivateIpAddress: aws.String("10.0.0.10"),
+	}
+
+	// An InService instance DescribeInstances returned without a usable
+	// address (e.g. a transient/eventually-consistent read): its hostname
+	// can't be derived this sweep, so Reconcile must report it in its error
+	// while still repairing the other records.
+	noAddrInstance := &ec2.Instance{InstanceId: aws.String("i-noaddr")}
+
+	group := &autoscaling.Group{
+		Tags: []*autoscaling.TagDescription{
+			{Key: aws.String("massive:DNS-SD:Route53:zone"), Value: aws.String("Z1")},
+		},
+		Instances: []*autoscaling.Instance{
+			{InstanceId: aws.String("i-live"), LifecycleState: aws.String(autoscaling.LifecycleStateInService)},
+			{InstanceId: aws.String("i-pending"), LifecycleState: aws.String(autoscaling.LifecycleStatePending)},
+			{InstanceId: aws.String("i-noaddr"), LifecycleState: aws.String(autoscaling.LifecycleStateInService)},
+		},
+	}
+
+	stale := &route53.ResourceRecordSet{
+		Name:            aws.String("ip-10-0-0-99.example.com."),
+		Type:            aws.String("A"),
+		TTL:             aws.Int64(60),
+		ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.99")}},
+	}
+	manual := &route53.ResourceRecordSet{
+		Name:            aws.String("www.example.com."),
+		Type:            aws.String("A"),
+		TTL:             aws.Int64(60),
+		ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("203.0.113.1")}},
+	}
+
+	r53 := &fakeRoute53{zoneName: "example.com.", rrsets: map[string]*route53.ResourceRecordSet{}, list: []*route53.ResourceRecordSet{stale, manual}}
+	reactor := NewWithClients(r53, &fakeAutoscaling{group: group}, &fakeEC2{instances: map[string]*ec2.Instance{
+		"i-live":   liveInstance,
+		"i-noaddr": noAddrInstance,
+	}})
+
+	err := reactor.Reconcile(context.Background(), "my-asg")
+	if err == nil {
+		t.Fatalf("expected an error reporting i-noaddr's unresolved address")
+	}
+
+	var upserted, deletedStale, touchedManual bool
+	for _, change := range r53.changes {
+		if *change.ResourceRecordSet.Name == "www.example.com." {
+			touchedManual = true
+		}
+		switch *change.Action {
+		case "UPSERT":
+			upserted = true
+		case "DELETE":
+			if *change.ResourceRecordSet.Name == "ip-10-0-0-99.example.com." {
+				deletedStale = true
+			}
+		}
+	}
+	if !upserted {
+		t.Fatalf("expected the live instance's missing A record to be upserted, got changes: %+v", r53.changes)
+	}
+	if !deletedStale {
+		t.Fatalf("expected the stale generated-hostname A record (no longer backed by a live instance) to be deleted, got changes: %+v", r53.changes)
+	}
+	if touchedManual {
+		t.Fatalf("expected the hand-managed www record at the same depth to be left alone, got changes: %+v", r53.changes)
+	}
+}
+
+func TestHandleEventBridge(t *testing.T) {
+	instance := &ec2.Instance{InstanceId: aws.String("i-0123456789abcdef0"), PrivateIpAddress: aws.String("10.0.0.5")}
+	group := &autoscaling.Group{
+		Tags: []*autoscaling.TagDescription{
+			{Key: aws.String("massive:DNS-SD:Route53:zone"), Value: aws.String("Z1")},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		detailType string
+		wantAction string
+		wantErr    bool
+	}{
+		{name: "launch successful upserts the A record", detailType: "EC2 Instance Launch Successful", wantAction: "UPSERT"},
+		{name: "terminate successful deletes the A record", detailType: "EC2 Instance Terminate Successful", wantAction: "DELETE"},
+		{name: "unrecognized detail-type errors instead of deleting", detailType: "EC2 Instance Launch Unsuccessful", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r53 := &fakeRoute53{zoneName: "example.com.", rrsets: map[string]*route53.ResourceRecordSet{}}
+			reactor := NewWithClients(r53, &fakeAutoscaling{group: group}, &fakeEC2{instance: instance})
+
+			evt := events.CloudWatchEvent{
+				Source:     "aws.autoscaling",
+				DetailType: tt.detailType,
+				Detail:     json.RawMessage(`{"EC2InstanceId":"i-0123456789abcdef0","AutoScalingGroupName":"my-asg"}`),
+			}
+
+			instanceID, err := reactor.HandleEventBridge(evt)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error for detail-type %q, got none", tt.detailType)
+				}
+				if len(r53.changes) != 0 {
+					t.Fatalf("expected no Route53 changes, got %+v", r53.changes)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if instanceID == nil || *instanceID != "i-0123456789abcdef0" {
+				t.Fatalf("expected instance ID to be returned, got %v", instanceID)
+			}
+			if len(r53.changes) == 0 || *r53.changes[0].Action != tt.wantAction {
+				t.Fatalf("expected a %s change, got %+v", tt.wantAction, r53.changes)
+			}
+		})
+	}
+}
+
+func TestHandleAny(t *testing.T) {
+	instance := &ec2.Instance{InstanceId: aws.String("i-0123456789abcdef0"), PrivateIpAddress: aws.String("10.0.0.5")}
+	group := &autoscaling.Group{
+		Tags: []*autoscaling.TagDescription{
+			{Key: aws.String("massive:DNS-SD:Route53:zone"), Value: aws.String("Z1")},
+		},
+	}
+
+	t.Run("dispatches EventBridge payloads by source", func(t *testing.T) {
+		r53 := &fakeRoute53{zoneName: "example.com.", rrsets: map[string]*route53.ResourceRecordSet{}}
+		reactor := NewWithClients(r53, &fakeAutoscaling{group: group}, &fakeEC2{instance: instance})
+
+		payload := json.RawMessage(`{
+			"source": "aws.autoscaling",
+			"detail-type": "EC2 Instance Launch Successful",
+			"detail": {"EC2InstanceId": "i-0123456789abcdef0", "AutoScalingGroupName": "my-asg"}
+		}`)
+
+		results, err := reactor.HandleAny(payload)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(results) != 1 || results[0].Err != nil || results[0].InstanceID == nil {
+			t.Fatalf("expected a single successful result, got %+v", results)
+		}
+		if len(r53.changes) == 0 || *r53.changes[0].Action != "UPSERT" {
+			t.Fatalf("expected an UPSERT change, got %+v", r53.changes)
+		}
+	})
+
+	t.Run("falls back to SNS for an unrecognized source", func(t *testing.T) {
+		r53 := &fakeRoute53{zoneName: "example.com.", rrsets: map[string]*route53.ResourceRecordSet{}}
+		reactor := NewWithClients(r53, &fakeAutoscaling{group: group}, &fakeEC2{instance: instance})
+
+		message := `{"EC2InstanceId":"i-0123456789abcdef0","AutoScalingGroupName":"my-asg","Event":"autoscaling:EC2_INSTANCE_LAUNCH"}`
+		payload, err := json.Marshal(events.SNSEvent{
+			Records: []events.SNSEventRecord{{SNS: events.SNSEntity{Message: message}}},
+		})
+		if err != nil {
+			t.Fatalf("failed to build SNS payload: %v", err)
+		}
+
+		results, err := reactor.HandleAny(payload)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(results) != 1 || results[0].Err != nil {
+			t.Fatalf("expected a single successful result, got %+v", results)
+		}
+		if len(r53.changes) == 0 || *r53.changes[0].Action != "UPSERT" {
+			t.Fatalf("expected an UPSERT change, got %+v", r53.changes)
+		}
+	})
+}